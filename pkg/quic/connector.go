@@ -21,6 +21,17 @@ type Connector struct {
 	transferRate memory.Size
 
 	config *quic.Config
+
+	// configCustomized records whether config was set by WithReceiveWindows
+	// or WithPathMTUDiscovery, as opposed to being left at its construction
+	// default, so WithTransport can refuse a combination it would silently
+	// ignore.
+	configCustomized bool
+
+	// transport, when set, is used to dial instead of opening a fresh UDP
+	// socket and session per dial, so callers sharing one Connector get
+	// true stream multiplexing and reduced UDP port pressure.
+	transport *Transport
 }
 
 // NewDefaultConnector instantiates a new instance of Connector.
@@ -40,6 +51,17 @@ func NewDefaultConnector(quicConfig *quic.Config) Connector {
 func (c Connector) DialContext(ctx context.Context, tlsConfig *tls.Config, address string) (_ rpc.ConnectorConn, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	if c.transport != nil {
+		conn, err := c.transport.DialContext(ctx, tlsConfig, address)
+		if err != nil {
+			return nil, err
+		}
+		return &timedConn{
+			ConnectorConn: conn,
+			rate:          c.transferRate,
+		}, nil
+	}
+
 	if tlsConfig == nil {
 		return nil, Error.New("tls config is not set")
 	}
@@ -90,3 +112,87 @@ func (c Connector) SetTransferRate(rate memory.Size) Connector {
 func (c Connector) TransferRate() memory.Size {
 	return c.transferRate
 }
+
+// WithTransport returns a QUIC connector that dials through the given
+// Transport instead of opening a fresh UDP socket and session per dial.
+// Callers that share a Transport across Connectors get true stream
+// multiplexing and reduced UDP port pressure.
+//
+// It panics if WithReceiveWindows or WithPathMTUDiscovery was already called
+// on c: the Transport dials with its own quic.Config fixed at NewTransport
+// time, so those settings would otherwise be silently dropped.
+func (c Connector) WithTransport(transport *Transport) Connector {
+	if c.configCustomized {
+		panic("quic: WithTransport called on a Connector with a customized quic.Config (from WithReceiveWindows or WithPathMTUDiscovery); " +
+			"the Transport dials with its own config, so those settings would be silently ignored")
+	}
+	c.transport = transport
+	return c
+}
+
+// WithReceiveWindows returns a QUIC connector with the given initial and
+// max flow-control receive windows for streams and connections, mapped onto
+// quic.Config's InitialStreamReceiveWindow, MaxStreamReceiveWindow,
+// InitialConnectionReceiveWindow and MaxConnectionReceiveWindow. Larger
+// windows let a single stream or connection make use of more in-flight data
+// over high-bandwidth, high-latency links to distant storage nodes.
+//
+// It panics if WithTransport was already called on c: the Transport dials
+// with its own quic.Config fixed at NewTransport time, so this setting would
+// otherwise be silently ignored.
+func (c Connector) WithReceiveWindows(initialStream, maxStream, initialConnection, maxConnection memory.Size) Connector {
+	if c.transport != nil {
+		panic("quic: WithReceiveWindows called on a Connector with a Transport set; the Transport dials with its own config, so this setting would be silently ignored")
+	}
+	cfg := c.configOrDefault()
+	cfg.InitialStreamReceiveWindow = uint64(initialStream)
+	cfg.MaxStreamReceiveWindow = uint64(maxStream)
+	cfg.InitialConnectionReceiveWindow = uint64(initialConnection)
+	cfg.MaxConnectionReceiveWindow = uint64(maxConnection)
+	c.config = cfg
+	c.configCustomized = true
+	return c
+}
+
+// WithPathMTUDiscovery returns a QUIC connector with path MTU discovery
+// enabled or disabled, mapped onto quic.Config.DisablePathMTUDiscovery.
+//
+// It panics if WithTransport was already called on c: the Transport dials
+// with its own quic.Config fixed at NewTransport time, so this setting would
+// otherwise be silently ignored.
+func (c Connector) WithPathMTUDiscovery(enabled bool) Connector {
+	if c.transport != nil {
+		panic("quic: WithPathMTUDiscovery called on a Connector with a Transport set; the Transport dials with its own config, so this setting would be silently ignored")
+	}
+	cfg := c.configOrDefault()
+	cfg.DisablePathMTUDiscovery = !enabled
+	c.config = cfg
+	c.configCustomized = true
+	return c
+}
+
+// WithPacing returns a QUIC connector that caps the rate at which data is
+// sent to at most rate per second. It reuses the same transfer-rate limiter
+// as SetTransferRate, giving operators a single pacing ceiling knob that
+// throttles uploads to distant storage nodes regardless of how it is set.
+//
+// WithPacing has no effect on the congestion control algorithm used for a
+// connection: the vendored quic-go only implements a Reno-like loss-based
+// controller, and this package does not expose a way to select BBR or any
+// other algorithm.
+func (c Connector) WithPacing(rate memory.Size) Connector {
+	return c.SetTransferRate(rate)
+}
+
+// configOrDefault returns a copy of the connector's quic.Config, or a new
+// default config if none has been set, so With* builders never mutate a
+// config shared with another Connector.
+func (c Connector) configOrDefault() *quic.Config {
+	if c.config == nil {
+		return &quic.Config{
+			MaxIdleTimeout: 15 * time.Minute,
+		}
+	}
+	cfg := *c.config
+	return &cfg
+}
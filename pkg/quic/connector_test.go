@@ -0,0 +1,70 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package quic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/memory"
+)
+
+func TestConnector_WithReceiveWindows(t *testing.T) {
+	connector := NewDefaultConnector(nil).
+		WithReceiveWindows(64*memory.KiB, 2*memory.MiB, 128*memory.KiB, 4*memory.MiB)
+
+	require.EqualValues(t, 64*memory.KiB, connector.config.InitialStreamReceiveWindow)
+	require.EqualValues(t, 2*memory.MiB, connector.config.MaxStreamReceiveWindow)
+	require.EqualValues(t, 128*memory.KiB, connector.config.InitialConnectionReceiveWindow)
+	require.EqualValues(t, 4*memory.MiB, connector.config.MaxConnectionReceiveWindow)
+}
+
+func TestConnector_WithPathMTUDiscovery(t *testing.T) {
+	connector := NewDefaultConnector(nil).WithPathMTUDiscovery(false)
+	require.True(t, connector.config.DisablePathMTUDiscovery)
+
+	connector = connector.WithPathMTUDiscovery(true)
+	require.False(t, connector.config.DisablePathMTUDiscovery)
+}
+
+func TestConnector_WithPacing(t *testing.T) {
+	connector := NewDefaultConnector(nil).WithPacing(10 * memory.MiB)
+	require.Equal(t, 10*memory.MiB, connector.TransferRate())
+}
+
+func TestConnector_BuildersDoNotShareConfig(t *testing.T) {
+	base := NewDefaultConnector(nil)
+
+	a := base.WithReceiveWindows(1*memory.KiB, 1*memory.KiB, 1*memory.KiB, 1*memory.KiB)
+	b := base.WithReceiveWindows(2*memory.KiB, 2*memory.KiB, 2*memory.KiB, 2*memory.KiB)
+
+	require.EqualValues(t, 1*memory.KiB, a.config.InitialStreamReceiveWindow)
+	require.EqualValues(t, 2*memory.KiB, b.config.InitialStreamReceiveWindow)
+}
+
+// TestConnector_TransportAndConfigAreMutuallyExclusive verifies that setting
+// a Transport after customizing the quic.Config, or customizing the
+// quic.Config after setting a Transport, panics instead of silently dialing
+// through the Transport with the customization dropped.
+func TestConnector_TransportAndConfigAreMutuallyExclusive(t *testing.T) {
+	transport, err := NewTransport(nil)
+	require.NoError(t, err)
+	defer func() { _ = transport.Close() }()
+
+	require.Panics(t, func() {
+		NewDefaultConnector(nil).
+			WithReceiveWindows(64*memory.KiB, 2*memory.MiB, 128*memory.KiB, 4*memory.MiB).
+			WithTransport(transport)
+	})
+
+	require.Panics(t, func() {
+		NewDefaultConnector(nil).WithTransport(transport).
+			WithReceiveWindows(64*memory.KiB, 2*memory.MiB, 128*memory.KiB, 4*memory.MiB)
+	})
+
+	require.Panics(t, func() {
+		NewDefaultConnector(nil).WithTransport(transport).WithPathMTUDiscovery(false)
+	})
+}
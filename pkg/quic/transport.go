@@ -0,0 +1,188 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+
+	"storj.io/common/peertls/tlsopts"
+	"storj.io/common/rpc"
+)
+
+// Transport owns a single long-lived UDP socket and multiplexes dials to the
+// same remote address over a shared quic.Session, opening only a new stream
+// per RPC. Sharing a Transport across Connectors avoids the UDP port pressure
+// and per-dial handshake cost of opening a fresh socket and session for every
+// call.
+type Transport struct {
+	config *quic.Config
+
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	closed   bool
+	sessions map[string]quic.Session
+}
+
+// NewTransport creates a Transport listening on a single UDP socket.
+// If no quic configuration is provided, default value will be used.
+func NewTransport(config *quic.Config) (*Transport, error) {
+	if config == nil {
+		config = &quic.Config{
+			MaxIdleTimeout: 15 * time.Minute,
+		}
+	}
+
+	rawConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	if !HasSufficientUDPReceiveBufferSize(rawConn) {
+		return nil, Error.New("failed to increase udp receive buffer size")
+	}
+
+	return &Transport{
+		config:   config,
+		conn:     rawConn,
+		sessions: make(map[string]quic.Session),
+	}, nil
+}
+
+// DialContext creates a quic connection, reusing a pooled quic.Session for
+// the destination address when one is already established and opening a new
+// stream on it. When no session is available for the address, a new one is
+// dialed over the transport's shared UDP socket and added to the pool.
+func (t *Transport) DialContext(ctx context.Context, tlsConfig *tls.Config, address string) (_ rpc.ConnectorConn, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if tlsConfig == nil {
+		return nil, Error.New("tls config is not set")
+	}
+	tlsConfigCopy := tlsConfig.Clone()
+	tlsConfigCopy.NextProtos = []string{tlsopts.StorjApplicationProtocol}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	sess, err := t.sessionFor(ctx, udpAddr, tlsConfigCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := sess.OpenStreamSync(ctx)
+	if err != nil {
+		// the session is no longer usable, so remove it and let the next
+		// dial to this address establish a fresh one.
+		t.dropSession(udpAddr.String(), sess)
+		return nil, Error.Wrap(err)
+	}
+
+	conn := &Conn{
+		session: sess,
+		stream:  stream,
+	}
+
+	return TrackClose(conn), nil
+}
+
+// sessionFor returns a pooled session for remoteAddr, dialing a new one over
+// the shared socket if none exists yet. The dial is passed tlsConfig
+// unmodified aside from NextProtos, so when the caller sets
+// tlsConfig.ClientSessionCache, quic-go attempts TLS session resumption using
+// it whenever a fresh session needs to be established for an address it has
+// seen before (e.g. after the pooled session for that address was dropped).
+// The dial uses quic.DialContext, which waits for the handshake to complete,
+// so no data is sent as 0-RTT early data.
+// A request whose session is already pooled skips the dial entirely and
+// never touches the session cache.
+func (t *Transport) sessionFor(ctx context.Context, remoteAddr *net.UDPAddr, tlsConfig *tls.Config) (quic.Session, error) {
+	key := remoteAddr.String()
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, Error.New("transport is closed")
+	}
+	if sess, ok := t.sessions[key]; ok {
+		t.mu.Unlock()
+		return sess, nil
+	}
+	t.mu.Unlock()
+
+	sess, err := quic.DialContext(ctx, t.conn, remoteAddr, remoteAddr.IP.String(), tlsConfig, t.config)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		_ = sess.CloseWithError(0, "transport closed")
+		return nil, Error.New("transport is closed")
+	}
+	if existing, ok := t.sessions[key]; ok {
+		// a concurrent dial to the same address won the race.
+		t.mu.Unlock()
+		_ = sess.CloseWithError(0, "superseded by concurrent dial")
+		return existing, nil
+	}
+	t.sessions[key] = sess
+	t.mu.Unlock()
+
+	go t.forgetOnClose(key, sess)
+
+	return sess, nil
+}
+
+// forgetOnClose removes sess from the pool once it is no longer usable, so a
+// later dial to the same address establishes a fresh session instead of
+// reusing a dead one.
+func (t *Transport) forgetOnClose(key string, sess quic.Session) {
+	<-sess.Context().Done()
+	t.dropSession(key, sess)
+}
+
+func (t *Transport) dropSession(key string, sess quic.Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions[key] == sess {
+		delete(t.sessions, key)
+	}
+}
+
+// SupportsDatagrams reports whether sessions dialed by this Transport
+// negotiate unreliable datagram support, i.e. whether quic.Config.EnableDatagrams
+// was set when the Transport was created. Callers implementing datagram-based
+// RPC on top of a pooled session should check this before attempting to send
+// or receive datagrams on it.
+func (t *Transport) SupportsDatagrams() bool {
+	return t.config.EnableDatagrams
+}
+
+// Close closes the underlying UDP socket and every pooled session.
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	for _, sess := range t.sessions {
+		_ = sess.CloseWithError(0, "transport closed")
+	}
+	t.sessions = nil
+
+	return t.conn.Close()
+}
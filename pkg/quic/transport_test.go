@@ -0,0 +1,141 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/peertls/tlsopts"
+	"storj.io/common/rpc"
+)
+
+// TestTransport_ConcurrentDialReusesSession starts a local QUIC listener and
+// fires many concurrent DialContext calls at it through a single Transport,
+// verifying that they settle on exactly one pooled session for the address
+// instead of leaving several redundant sessions in the pool. Dials that lose
+// the race may still open a session of their own, which is then closed.
+func TestTransport_ConcurrentDialReusesSession(t *testing.T) {
+	serverTLSConfig := generateTestTLSConfig(t)
+	clientTLSConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{tlsopts.StorjApplicationProtocol},
+	}
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", serverTLSConfig, nil)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		for {
+			sess, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go acceptStreams(sess)
+		}
+	}()
+
+	transport, err := NewTransport(nil)
+	require.NoError(t, err)
+	defer func() { _ = transport.Close() }()
+
+	const concurrency = 16
+	var wg sync.WaitGroup
+	conns := make([]rpc.ConnectorConn, concurrency)
+	errs := make([]error, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			conn, err := transport.DialContext(ctx, clientTLSConfig, listener.Addr().String())
+			errs[i] = err
+			if err == nil {
+				conns[i] = conn
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		require.NoErrorf(t, err, "dial %d", i)
+	}
+	for _, conn := range conns {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+
+	transport.mu.Lock()
+	sessionCount := len(transport.sessions)
+	transport.mu.Unlock()
+	require.Equal(t, 1, sessionCount, "all concurrent dials to the same address should share one pooled session")
+
+	_ = listener.Close()
+	<-serverDone
+}
+
+// TestTransport_SupportsDatagrams verifies SupportsDatagrams reflects
+// quic.Config.EnableDatagrams as set on the Transport at construction.
+func TestTransport_SupportsDatagrams(t *testing.T) {
+	withoutDatagrams, err := NewTransport(nil)
+	require.NoError(t, err)
+	defer func() { _ = withoutDatagrams.Close() }()
+	require.False(t, withoutDatagrams.SupportsDatagrams())
+
+	withDatagrams, err := NewTransport(&quic.Config{EnableDatagrams: true})
+	require.NoError(t, err)
+	defer func() { _ = withDatagrams.Close() }()
+	require.True(t, withDatagrams.SupportsDatagrams())
+}
+
+func acceptStreams(sess quic.Session) {
+	for {
+		stream, err := sess.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		_ = stream.Close()
+	}
+}
+
+// generateTestTLSConfig builds a throwaway self-signed TLS config for the
+// local QUIC listener used in these tests.
+func generateTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{derBytes},
+		PrivateKey:  key,
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{tlsopts.StorjApplicationProtocol},
+	}
+}
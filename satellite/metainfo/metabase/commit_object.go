@@ -45,12 +45,12 @@ func (db *DB) CommitObjectWithSegments(ctx context.Context, opts CommitObjectWit
 		// TODO: should we prevent this from executing when the object has been committed
 		// currently this requires quite a lot of database communication, so invalid handling can be expensive.
 
-		segmentsInDatabase, err := fetchSegmentsForCommit(ctx, tx, opts.StreamID)
+		nextSegmentInDatabase, err := fetchSegmentsForCommit(ctx, tx, opts.StreamID)
 		if err != nil {
 			return err
 		}
 
-		finalSegments, segmentsToDelete, err := determineCommitActions(opts.Segments, segmentsInDatabase)
+		finalSegments, segmentsToDelete, err := determineCommitActions(opts.Segments, nextSegmentInDatabase)
 		if err != nil {
 			return err
 		}
@@ -66,26 +66,9 @@ func (db *DB) CommitObjectWithSegments(ctx context.Context, opts CommitObjectWit
 		}
 
 		// TODO: would we even need this when we make main index plain_offset?
-		fixedSegmentSize := int32(0)
-		if len(finalSegments) > 0 {
-			fixedSegmentSize = finalSegments[0].EncryptedSize
-			for i, seg := range finalSegments {
-				if seg.Position.Part != 0 {
-					fixedSegmentSize = -1
-					break
-				}
-				if i < len(finalSegments)-1 && seg.EncryptedSize != fixedSegmentSize {
-					fixedSegmentSize = -1
-					break
-				}
-			}
-		}
-
-		var totalPlainSize, totalEncryptedSize int64
-		for _, seg := range finalSegments {
-			totalPlainSize += int64(seg.PlainSize)
-			totalEncryptedSize += int64(seg.EncryptedSize)
-		}
+		fixedSegmentSize := fixedSegmentSizeOf(finalSegments)
+		totalPlainSize := totalPlainSizeOf(finalSegments)
+		totalEncryptedSize := totalEncryptedSizeOf(finalSegments)
 
 		err = tx.QueryRow(ctx, `
 			UPDATE objects SET
@@ -173,30 +156,47 @@ type segmentInfoForCommit struct {
 	PlainSize     int32
 }
 
-// fetchSegmentsForCommit loads information necessary for validating segment existence and offsets.
-func fetchSegmentsForCommit(ctx context.Context, tx tagsql.Tx, streamID uuid.UUID) (segments []segmentInfoForCommit, err error) {
+// nextSegmentFunc is a cursor over the segments of a commit, returning one
+// row at a time instead of materializing the full result set. ok is false
+// once the cursor is exhausted.
+type nextSegmentFunc func() (segment segmentInfoForCommit, ok bool, err error)
+
+// fetchSegmentsForCommit returns a cursor over the segments necessary for
+// validating segment existence and offsets, without loading them all into
+// memory up front. The underlying rows are closed once the cursor is
+// exhausted or returns an error.
+func fetchSegmentsForCommit(ctx context.Context, tx tagsql.Tx, streamID uuid.UUID) (_ nextSegmentFunc, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	err = withRows(tx.Query(ctx, `
+	rows, err := tx.Query(ctx, `
 		SELECT position, encrypted_size, plain_offset, plain_size
 		FROM segments
 		WHERE stream_id = $1
 		ORDER BY position
-	`, streamID))(func(rows tagsql.Rows) error {
-		for rows.Next() {
-			var segment segmentInfoForCommit
-			err := rows.Scan(&segment.Position, &segment.EncryptedSize, &segment.PlainOffset, &segment.PlainSize)
-			if err != nil {
-				return Error.New("failed to scan segments: %w", err)
-			}
-			segments = append(segments, segment)
-		}
-		return nil
-	})
+	`, streamID)
 	if err != nil {
 		return nil, Error.New("failed to fetch segments: %w", err)
 	}
-	return segments, nil
+
+	return func() (segment segmentInfoForCommit, ok bool, err error) {
+		if !rows.Next() {
+			err = rows.Err()
+			if closeErr := rows.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return segmentInfoForCommit{}, false, Error.New("failed to fetch segments: %w", err)
+			}
+			return segmentInfoForCommit{}, false, nil
+		}
+
+		err = rows.Scan(&segment.Position, &segment.EncryptedSize, &segment.PlainOffset, &segment.PlainSize)
+		if err != nil {
+			_ = rows.Close()
+			return segmentInfoForCommit{}, false, Error.New("failed to scan segments: %w", err)
+		}
+		return segment, true, nil
+	}, nil
 }
 
 type segmentToCommit struct {
@@ -207,11 +207,13 @@ type segmentToCommit struct {
 }
 
 // determineCommitActions detects how should the database be updated and which segments should be deleted.
-func determineCommitActions(segments []SegmentPosition, segmentsInDatabase []segmentInfoForCommit) (commit []segmentToCommit, toDelete []SegmentPosition, err error) {
+// segmentsInDatabase is consumed as a cursor rather than a preloaded slice, so that committing an object
+// with many segments never requires materializing the full database-side segment list in memory.
+func determineCommitActions(segments []SegmentPosition, segmentsInDatabase nextSegmentFunc) (commit []segmentToCommit, toDelete []SegmentPosition, err error) {
 	var invalidSegments errs.Group
 
 	commit = make([]segmentToCommit, 0, len(segments))
-	diffSegmentsWithDatabase(segments, segmentsInDatabase, func(a *SegmentPosition, b *segmentInfoForCommit) {
+	err = diffSegmentsWithDatabase(segments, segmentsInDatabase, func(a *SegmentPosition, b *segmentInfoForCommit) {
 		// If we do not have an appropriate segment in the database it means
 		// either the segment was deleted before commit finished or the
 		// segment was not uploaded. Either way we need to fail the commit.
@@ -235,6 +237,9 @@ func determineCommitActions(segments []SegmentPosition, segmentsInDatabase []seg
 			EncryptedSize:  b.EncryptedSize,
 		})
 	})
+	if err != nil {
+		return nil, nil, Error.New("failed to read segments: %w", err)
+	}
 
 	if err := invalidSegments.Err(); err != nil {
 		return nil, nil, Error.New("segments and database does not match: %v", err)
@@ -330,23 +335,44 @@ func deleteSegmentsNotInCommit(ctx context.Context, tx tagsql.Tx, streamID uuid.
 }
 
 // diffSegmentsWithDatabase matches up segment positions with their database information.
-func diffSegmentsWithDatabase(as []SegmentPosition, bs []segmentInfoForCommit, cb func(a *SegmentPosition, b *segmentInfoForCommit)) {
-	for len(as) > 0 && len(bs) > 0 {
-		if as[0] == bs[0].Position {
-			cb(&as[0], &bs[0])
-			as, bs = as[1:], bs[1:]
-		} else if as[0].Less(bs[0].Position) {
-			cb(&as[0], nil)
-			as = as[1:]
-		} else {
-			cb(nil, &bs[0])
-			bs = bs[1:]
+// as is walked by index rather than by slicing it, and the database-side segments are pulled
+// one row at a time from next, so they are never materialized into a slice.
+func diffSegmentsWithDatabase(as []SegmentPosition, next nextSegmentFunc, cb func(a *SegmentPosition, b *segmentInfoForCommit)) error {
+	b, bOk, err := next()
+	if err != nil {
+		return err
+	}
+
+	ai := 0
+	for ai < len(as) && bOk {
+		a := as[ai]
+		switch {
+		case a == b.Position:
+			cb(&a, &b)
+			ai++
+			b, bOk, err = next()
+		case a.Less(b.Position):
+			cb(&a, nil)
+			ai++
+		default:
+			cb(nil, &b)
+			b, bOk, err = next()
+		}
+		if err != nil {
+			return err
 		}
 	}
-	for i := range as {
-		cb(&as[i], nil)
+
+	for ; ai < len(as); ai++ {
+		a := as[ai]
+		cb(&a, nil)
 	}
-	for i := range bs {
-		cb(nil, &bs[i])
+	for bOk {
+		cb(nil, &b)
+		b, bOk, err = next()
+		if err != nil {
+			return err
+		}
 	}
-}
\ No newline at end of file
+	return nil
+}
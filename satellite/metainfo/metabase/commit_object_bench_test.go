@@ -0,0 +1,59 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metabase
+
+import (
+	"testing"
+)
+
+// BenchmarkDetermineCommitActions_10kSegments exercises determineCommitActions
+// against a 10k segment object, with segmentsInDatabase delivered through the
+// same nextSegmentFunc cursor shape used against the database, rather than a
+// preloaded slice.
+//
+// benchSegmentCursor is an in-memory fake: this benchmark does not go through
+// fetchSegmentsForCommit or a real tagsql.Rows, so it does not exercise an
+// actual database round trip and is not proof that the streaming change
+// reduces memory use against postgres. This tree has no DB test harness
+// available to benchmark that path; it only benchmarks the already-cheap
+// matching loop against the cursor interface.
+func BenchmarkDetermineCommitActions_10kSegments(b *testing.B) {
+	const segmentCount = 10000
+
+	positions := make([]SegmentPosition, segmentCount)
+	for i := range positions {
+		positions[i] = SegmentPosition{Index: uint32(i)}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		next := benchSegmentCursor(positions)
+		if _, _, err := determineCommitActions(positions, next); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchSegmentCursor returns a nextSegmentFunc that yields one segment per
+// position at a time, mirroring how fetchSegmentsForCommit streams rows out
+// of tagsql.Rows without ever holding the full result set in memory.
+func benchSegmentCursor(positions []SegmentPosition) nextSegmentFunc {
+	i := 0
+	return func() (segmentInfoForCommit, bool, error) {
+		if i >= len(positions) {
+			return segmentInfoForCommit{}, false, nil
+		}
+		const segmentSize = 64 * 1024
+		segment := segmentInfoForCommit{
+			Position:      positions[i],
+			EncryptedSize: segmentSize,
+			PlainOffset:   int64(i) * segmentSize,
+			PlainSize:     segmentSize,
+		}
+		i++
+		return segment, true, nil
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metabase
+
+import (
+	"context"
+	"fmt"
+
+	"storj.io/common/storj"
+	"storj.io/common/uuid"
+	"storj.io/storj/private/dbutil/pgutil"
+	"storj.io/storj/private/dbutil/txutil"
+	"storj.io/storj/private/tagsql"
+)
+
+// CommitResult is the outcome of committing a single object as part of a
+// CommitObjectsWithSegments batch. Err is set when the object could not be
+// committed; in that case Object and DeletedSegments are zero values.
+type CommitResult struct {
+	Object          Object
+	DeletedSegments []DeletedSegmentInfo
+	Err             error
+}
+
+// commitObjectsWithSegmentsPlan is the work derived for a single object
+// of the batch, once its final segments have been determined.
+type commitObjectsWithSegmentsPlan struct {
+	index int
+	opts  CommitObjectWithSegments
+
+	finalSegments    []segmentToCommit
+	segmentsToDelete []SegmentPosition
+
+	fixedSegmentSize                   int32
+	totalPlainSize, totalEncryptedSize int64
+}
+
+// CommitObjectsWithSegments commits a batch of pending objects to the
+// database in a single transaction. It fetches the segments for every
+// requested stream ID with one query, determines the final segments and
+// deletions for each object in-process, and then issues one bulk UPDATE of
+// objects and one bulk DELETE of segments. Segment plain offsets are still
+// updated per object, each inside its own savepoint, so those statements
+// take a round trip per object. This is intended for multipart completion and bulk migrations
+// that commit many objects at once.
+//
+// Validation of each object (segment ordering, missing segments, the object
+// being pending) is preserved per-object: a failure for one object does not
+// abort the others. The returned slice has the same length and order as
+// opts, with CommitResult.Err set for objects that failed to commit.
+func (db *DB) CommitObjectsWithSegments(ctx context.Context, opts []CommitObjectWithSegments) (results []CommitResult, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	results = make([]CommitResult, len(opts))
+
+	streamIDs := make([]uuid.UUID, 0, len(opts))
+	for i, o := range opts {
+		if err := o.ObjectStream.Verify(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if err := verifySegmentOrder(o.Segments); err != nil {
+			results[i].Err = err
+			continue
+		}
+		streamIDs = append(streamIDs, o.StreamID)
+	}
+
+	if len(streamIDs) == 0 {
+		return results, nil
+	}
+
+	err = txutil.WithTx(ctx, db.db, nil, func(ctx context.Context, tx tagsql.Tx) error {
+		segmentsByStream, err := fetchSegmentsForCommitBatch(ctx, tx, streamIDs)
+		if err != nil {
+			return err
+		}
+
+		var plans []commitObjectsWithSegmentsPlan
+		for i, o := range opts {
+			if results[i].Err != nil {
+				continue
+			}
+
+			finalSegments, segmentsToDelete, err := determineCommitActions(o.Segments, sliceSegmentCursor(segmentsByStream[o.StreamID]))
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			plans = append(plans, commitObjectsWithSegmentsPlan{
+				index:            i,
+				opts:             o,
+				finalSegments:    finalSegments,
+				segmentsToDelete: segmentsToDelete,
+
+				fixedSegmentSize:   fixedSegmentSizeOf(finalSegments),
+				totalPlainSize:     totalPlainSizeOf(finalSegments),
+				totalEncryptedSize: totalEncryptedSizeOf(finalSegments),
+			})
+		}
+
+		if len(plans) == 0 {
+			return nil
+		}
+
+		for _, plan := range plans {
+			// Each plan's offset update runs inside its own savepoint, so a
+			// failure for one object (e.g. a segment that is no longer in
+			// the database, so not all offsets could be updated) only
+			// rolls back that object's statement and leaves the shared
+			// transaction usable for the remaining plans, instead of
+			// aborting the whole batch.
+			err := withSavepoint(ctx, tx, fmt.Sprintf("commit_offsets_%d", plan.index), func() error {
+				return updateSegmentOffsets(ctx, tx, plan.opts.StreamID, plan.finalSegments)
+			})
+			if err != nil {
+				results[plan.index].Err = err
+			}
+		}
+
+		// Only plans that are still error-free may reach the bulk queries:
+		// a failed plan must neither flip its object to committed nor have
+		// its stale segments deleted.
+		committable := filterPlansWithoutErrors(plans, results)
+
+		if len(committable) == 0 {
+			return nil
+		}
+
+		committed, err := updateObjectsBatch(ctx, tx, committable)
+		if err != nil {
+			return err
+		}
+		for streamID, object := range committed {
+			results[indexByStreamID(committable, streamID)].Object = object
+		}
+
+		for _, plan := range committable {
+			if _, ok := committed[plan.opts.StreamID]; !ok {
+				results[plan.index].Err = storj.ErrObjectNotFound.Wrap(
+					Error.New("object with specified version and pending status is missing"))
+			}
+		}
+
+		// Committing the object is also a prerequisite for deleting its
+		// leftover segments, so drop plans that turned out to be missing.
+		deletable := filterPlansWithoutErrors(committable, results)
+
+		deleted, err := deleteSegmentsNotInCommitBatch(ctx, tx, deletable)
+		if err != nil {
+			return err
+		}
+		for streamID, d := range deleted {
+			results[indexByStreamID(deletable, streamID)].DeletedSegments = d
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// withSavepoint runs fn inside a named savepoint on tx, rolling the
+// savepoint back (but not the whole transaction) if fn fails, so a single
+// plan's failure doesn't abort the rest of the batch sharing tx.
+func withSavepoint(ctx context.Context, tx tagsql.Tx, name string, fn func() error) (err error) {
+	if _, err := tx.Exec(ctx, "SAVEPOINT "+name); err != nil {
+		return Error.New("failed to create savepoint: %w", err)
+	}
+
+	if err := fn(); err != nil {
+		if _, rollbackErr := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			return Error.New("failed to roll back savepoint: %w", rollbackErr)
+		}
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return Error.New("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+// filterPlansWithoutErrors returns the subset of plans whose results entry
+// (looked up by plan.index) has no error recorded yet, preserving order.
+// It reuses plans' backing array, so the slice passed in must not be used
+// again afterwards.
+func filterPlansWithoutErrors(plans []commitObjectsWithSegmentsPlan, results []CommitResult) []commitObjectsWithSegmentsPlan {
+	filtered := plans[:0]
+	for _, plan := range plans {
+		if results[plan.index].Err == nil {
+			filtered = append(filtered, plan)
+		}
+	}
+	return filtered
+}
+
+func indexByStreamID(plans []commitObjectsWithSegmentsPlan, streamID uuid.UUID) int {
+	for _, plan := range plans {
+		if plan.opts.StreamID == streamID {
+			return plan.index
+		}
+	}
+	return -1
+}
+
+func fixedSegmentSizeOf(finalSegments []segmentToCommit) int32 {
+	fixedSegmentSize := int32(0)
+	if len(finalSegments) > 0 {
+		fixedSegmentSize = finalSegments[0].EncryptedSize
+		for i, seg := range finalSegments {
+			if seg.Position.Part != 0 {
+				fixedSegmentSize = -1
+				break
+			}
+			if i < len(finalSegments)-1 && seg.EncryptedSize != fixedSegmentSize {
+				fixedSegmentSize = -1
+				break
+			}
+		}
+	}
+	return fixedSegmentSize
+}
+
+func totalPlainSizeOf(finalSegments []segmentToCommit) int64 {
+	var total int64
+	for _, seg := range finalSegments {
+		total += int64(seg.PlainSize)
+	}
+	return total
+}
+
+func totalEncryptedSizeOf(finalSegments []segmentToCommit) int64 {
+	var total int64
+	for _, seg := range finalSegments {
+		total += int64(seg.EncryptedSize)
+	}
+	return total
+}
+
+// sliceSegmentCursor adapts an already-loaded slice of segments to the
+// nextSegmentFunc cursor shape expected by determineCommitActions, so the
+// batch path can reuse the same per-object matching logic as the single
+// object commit path while still fetching all streams with one query.
+func sliceSegmentCursor(segments []segmentInfoForCommit) nextSegmentFunc {
+	i := 0
+	return func() (segmentInfoForCommit, bool, error) {
+		if i >= len(segments) {
+			return segmentInfoForCommit{}, false, nil
+		}
+		segment := segments[i]
+		i++
+		return segment, true, nil
+	}
+}
+
+// fetchSegmentsForCommitBatch loads the committing segments for every
+// requested stream ID with a single query.
+func fetchSegmentsForCommitBatch(ctx context.Context, tx tagsql.Tx, streamIDs []uuid.UUID) (segments map[uuid.UUID][]segmentInfoForCommit, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	segments = make(map[uuid.UUID][]segmentInfoForCommit, len(streamIDs))
+
+	err = withRows(tx.Query(ctx, `
+		SELECT stream_id, position, encrypted_size, plain_offset, plain_size
+		FROM segments
+		WHERE stream_id = ANY($1)
+		ORDER BY stream_id, position
+	`, pgutil.UUIDArray(streamIDs)))(func(rows tagsql.Rows) error {
+		for rows.Next() {
+			var streamID uuid.UUID
+			var segment segmentInfoForCommit
+			err := rows.Scan(&streamID, &segment.Position, &segment.EncryptedSize, &segment.PlainOffset, &segment.PlainSize)
+			if err != nil {
+				return Error.New("failed to scan segments: %w", err)
+			}
+			segments[streamID] = append(segments[streamID], segment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, Error.New("failed to fetch segments: %w", err)
+	}
+	return segments, nil
+}
+
+// updateObjectsBatch commits every plan's object with a single bulk UPDATE,
+// returning the committed state keyed by stream ID for the objects that were
+// actually pending. Stream IDs not present in the result were not committed
+// because no matching pending object exists.
+func updateObjectsBatch(ctx context.Context, tx tagsql.Tx, plans []commitObjectsWithSegmentsPlan) (committed map[uuid.UUID]Object, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	committed = make(map[uuid.UUID]Object, len(plans))
+
+	var projectIDs, bucketNames, objectKeys, streamIDs [][]byte
+	var versions []int64
+	var segmentCounts, fixedSegmentSizes []int32
+	var metadataNonces, metadata, metadataKeys [][]byte
+	var totalPlainSizes, totalEncryptedSizes []int64
+
+	for _, plan := range plans {
+		o := plan.opts
+		projectIDs = append(projectIDs, o.ProjectID[:])
+		bucketNames = append(bucketNames, []byte(o.BucketName))
+		objectKeys = append(objectKeys, []byte(o.ObjectKey))
+		versions = append(versions, int64(o.Version))
+		streamIDs = append(streamIDs, o.StreamID[:])
+		segmentCounts = append(segmentCounts, int32(len(plan.finalSegments)))
+		fixedSegmentSizes = append(fixedSegmentSizes, plan.fixedSegmentSize)
+		metadataNonces = append(metadataNonces, o.EncryptedMetadataNonce)
+		metadata = append(metadata, o.EncryptedMetadata)
+		metadataKeys = append(metadataKeys, o.EncryptedMetadataEncryptedKey)
+		totalPlainSizes = append(totalPlainSizes, plan.totalPlainSize)
+		totalEncryptedSizes = append(totalEncryptedSizes, plan.totalEncryptedSize)
+	}
+
+	err = withRows(tx.Query(ctx, `
+		UPDATE objects SET
+			status                           = `+committedStatus+`,
+			segment_count                    = P.segment_count,
+			encrypted_metadata_nonce         = P.encrypted_metadata_nonce,
+			encrypted_metadata               = P.encrypted_metadata,
+			encrypted_metadata_encrypted_key = P.encrypted_metadata_encrypted_key,
+			total_plain_size                 = P.total_plain_size,
+			total_encrypted_size             = P.total_encrypted_size,
+			fixed_segment_size               = P.fixed_segment_size,
+			zombie_deletion_deadline         = NULL
+		FROM (
+			SELECT unnest($1::BYTEA[]) AS project_id,
+			       unnest($2::BYTEA[]) AS bucket_name,
+			       unnest($3::BYTEA[]) AS object_key,
+			       unnest($4::INT8[])  AS version,
+			       unnest($5::BYTEA[]) AS stream_id,
+			       unnest($6::INT4[])  AS segment_count,
+			       unnest($7::BYTEA[]) AS encrypted_metadata_nonce,
+			       unnest($8::BYTEA[]) AS encrypted_metadata,
+			       unnest($9::BYTEA[]) AS encrypted_metadata_encrypted_key,
+			       unnest($10::INT8[]) AS total_plain_size,
+			       unnest($11::INT8[]) AS total_encrypted_size,
+			       unnest($12::INT4[]) AS fixed_segment_size
+		) P
+		WHERE
+			objects.project_id  = P.project_id AND
+			objects.bucket_name = P.bucket_name AND
+			objects.object_key  = P.object_key AND
+			objects.version     = P.version AND
+			objects.stream_id   = P.stream_id AND
+			objects.status      = `+pendingStatus+`
+		RETURNING
+			objects.stream_id, objects.project_id, objects.bucket_name, objects.object_key, objects.version,
+			objects.segment_count, objects.encrypted_metadata_nonce, objects.encrypted_metadata, objects.encrypted_metadata_encrypted_key,
+			objects.total_plain_size, objects.total_encrypted_size, objects.fixed_segment_size,
+			objects.created_at, objects.expires_at, objects.encryption
+	`,
+		pgutil.ByteaArray(projectIDs), pgutil.ByteaArray(bucketNames), pgutil.ByteaArray(objectKeys),
+		pgutil.Int8Array(versions), pgutil.ByteaArray(streamIDs), pgutil.Int4Array(segmentCounts),
+		pgutil.ByteaArray(metadataNonces), pgutil.ByteaArray(metadata), pgutil.ByteaArray(metadataKeys),
+		pgutil.Int8Array(totalPlainSizes), pgutil.Int8Array(totalEncryptedSizes), pgutil.Int4Array(fixedSegmentSizes),
+	))(func(rows tagsql.Rows) error {
+		for rows.Next() {
+			var object Object
+			err := rows.Scan(
+				&object.StreamID, &object.ProjectID, &object.BucketName, &object.ObjectKey, &object.Version,
+				&object.SegmentCount, &object.EncryptedMetadataNonce, &object.EncryptedMetadata, &object.EncryptedMetadataEncryptedKey,
+				&object.TotalPlainSize, &object.TotalEncryptedSize, &object.FixedSegmentSize,
+				&object.CreatedAt, &object.ExpiresAt, encryptionParameters{&object.Encryption},
+			)
+			if err != nil {
+				return Error.New("failed to scan updated object: %w", err)
+			}
+			object.Status = Committed
+			committed[object.StreamID] = object
+		}
+		return nil
+	})
+	if err != nil {
+		// A non-matching stream ID simply produces no loop iteration above,
+		// not a sql.ErrNoRows from this tx.Query/rows.Next() form of update,
+		// so any error here is a real failure of the bulk UPDATE itself.
+		return nil, Error.New("failed to update objects: %w", err)
+	}
+	return committed, nil
+}
+
+// deleteSegmentsNotInCommitBatch deletes, in a single query, the segments
+// that are no longer part of the final object for each plan, returning the
+// deleted remote segment info grouped by stream ID.
+func deleteSegmentsNotInCommitBatch(ctx context.Context, tx tagsql.Tx, plans []commitObjectsWithSegmentsPlan) (deleted map[uuid.UUID][]DeletedSegmentInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	deleted = make(map[uuid.UUID][]DeletedSegmentInfo)
+
+	var streamIDs [][]byte
+	var positions []int64
+	for _, plan := range plans {
+		for _, pos := range plan.segmentsToDelete {
+			streamIDs = append(streamIDs, plan.opts.StreamID[:])
+			positions = append(positions, int64(pos.Encode()))
+		}
+	}
+	if len(streamIDs) == 0 {
+		return deleted, nil
+	}
+
+	err = withRows(tx.Query(ctx, `
+		DELETE FROM segments
+		WHERE (stream_id, position) IN (
+			SELECT unnest($1::BYTEA[]), unnest($2::INT8[])
+		)
+		RETURNING stream_id, root_piece_id, remote_pieces
+	`, pgutil.ByteaArray(streamIDs), pgutil.Int8Array(positions)))(func(rows tagsql.Rows) error {
+		for rows.Next() {
+			var streamID uuid.UUID
+			var d DeletedSegmentInfo
+			err := rows.Scan(&streamID, &d.RootPieceID, &d.Pieces)
+			if err != nil {
+				return Error.New("failed to scan segments: %w", err)
+			}
+			// we don't need to report info about inline segments
+			if d.RootPieceID.IsZero() {
+				continue
+			}
+			deleted[streamID] = append(deleted[streamID], d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, Error.New("unable to delete segments: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2021 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package metabase
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/uuid"
+)
+
+func TestFixedSegmentSizeOf(t *testing.T) {
+	require.EqualValues(t, 0, fixedSegmentSizeOf(nil))
+
+	require.EqualValues(t, 100, fixedSegmentSizeOf([]segmentToCommit{
+		{Position: SegmentPosition{Index: 0}, EncryptedSize: 100},
+		{Position: SegmentPosition{Index: 1}, EncryptedSize: 100},
+	}))
+
+	// a trailing, smaller last segment is fine.
+	require.EqualValues(t, 100, fixedSegmentSizeOf([]segmentToCommit{
+		{Position: SegmentPosition{Index: 0}, EncryptedSize: 100},
+		{Position: SegmentPosition{Index: 1}, EncryptedSize: 40},
+	}))
+
+	// differing sizes among non-last segments disable the fixed size.
+	require.EqualValues(t, -1, fixedSegmentSizeOf([]segmentToCommit{
+		{Position: SegmentPosition{Index: 0}, EncryptedSize: 100},
+		{Position: SegmentPosition{Index: 1}, EncryptedSize: 40},
+		{Position: SegmentPosition{Index: 2}, EncryptedSize: 100},
+	}))
+
+	// any multipart segment (Part != 0) disables the fixed size.
+	require.EqualValues(t, -1, fixedSegmentSizeOf([]segmentToCommit{
+		{Position: SegmentPosition{Part: 1, Index: 0}, EncryptedSize: 100},
+	}))
+}
+
+func TestTotalPlainSizeOf(t *testing.T) {
+	require.EqualValues(t, 0, totalPlainSizeOf(nil))
+	require.EqualValues(t, 30, totalPlainSizeOf([]segmentToCommit{
+		{PlainSize: 10},
+		{PlainSize: 20},
+	}))
+}
+
+func TestTotalEncryptedSizeOf(t *testing.T) {
+	require.EqualValues(t, 0, totalEncryptedSizeOf(nil))
+	require.EqualValues(t, 30, totalEncryptedSizeOf([]segmentToCommit{
+		{EncryptedSize: 10},
+		{EncryptedSize: 20},
+	}))
+}
+
+func TestIndexByStreamID(t *testing.T) {
+	streamA, streamB := uuid.UUID{1}, uuid.UUID{2}
+
+	plans := []commitObjectsWithSegmentsPlan{
+		{index: 0, opts: CommitObjectWithSegments{ObjectStream: ObjectStream{StreamID: streamA}}},
+		{index: 3, opts: CommitObjectWithSegments{ObjectStream: ObjectStream{StreamID: streamB}}},
+	}
+
+	require.Equal(t, 0, indexByStreamID(plans, streamA))
+	require.Equal(t, 3, indexByStreamID(plans, streamB))
+	require.Equal(t, -1, indexByStreamID(plans, uuid.UUID{9}))
+}
+
+// TestFilterPlansWithoutErrors exercises the per-object partial-failure
+// bookkeeping that CommitObjectsWithSegments relies on, without touching a
+// database: one plan failed an earlier savepoint-guarded offset update, one
+// plan is missing because its pending object disappeared, and one plan never
+// failed at all.
+func TestFilterPlansWithoutErrors(t *testing.T) {
+	plans := []commitObjectsWithSegmentsPlan{
+		{index: 0},
+		{index: 1},
+		{index: 2},
+	}
+	results := make([]CommitResult, 3)
+
+	// plan 0: failed its savepoint-guarded offset update.
+	results[0].Err = errors.New("offset update failed: not all segments were updated")
+	// plan 1: its pending object was gone by the time of the bulk UPDATE.
+	results[1].Err = errors.New("object with specified version and pending status is missing")
+	// plan 2 never failed, so it should survive filtering.
+
+	filtered := filterPlansWithoutErrors(plans, results)
+
+	require.Len(t, filtered, 1)
+	require.Equal(t, 2, filtered[0].index)
+}
+
+func TestSliceSegmentCursor(t *testing.T) {
+	segments := []segmentInfoForCommit{
+		{Position: SegmentPosition{Index: 0}},
+		{Position: SegmentPosition{Index: 1}},
+	}
+
+	next := sliceSegmentCursor(segments)
+
+	got, ok, err := next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, segments[0], got)
+
+	got, ok, err = next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, segments[1], got)
+
+	_, ok, err = next()
+	require.NoError(t, err)
+	require.False(t, ok)
+}